@@ -0,0 +1,341 @@
+/*
+Copyright 2020 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package assetexchange implements the client side of an HTLC asset
+// exchange whose counterparty runs on a non-Fabric DLT, such as
+// Hyperledger Besu, rather than another Fabric network. The chaincode
+// calls it drives are the same "LockAsset"/"ClaimAsset"/"UnlockAsset"
+// family the Fabric-to-Fabric flows use, since the local chaincode does
+// not need to know what ledger holds the other leg of the exchange; only
+// the hashlock encoding needs to be agreed with the counterparty
+// contract (e.g. Besu's AssetExchangeContract.sol).
+package assetexchange
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger-labs/weaver-dlt-interoperability/common/protos-go/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// ContractHandler is the chaincode invocation surface LockAsset,
+// ClaimAsset, ReclaimAsset and IsAssetLocked need. It is satisfied by a
+// Fabric Gateway contract handle, the same one used for Fabric-to-Fabric
+// exchanges, so this package works unchanged whether the counterparty is
+// Besu or another Fabric network.
+type ContractHandler interface {
+	SubmitTransaction(string, ...string) ([]byte, error)
+	EvaluateTransaction(string, ...string) ([]byte, error)
+}
+
+// AssetType selects which of the chaincode's fungible or non-fungible
+// HTLC entry points a call should use.
+type AssetType int
+
+const (
+	NonFungible AssetType = iota
+	Fungible
+)
+
+// helper functions to log and return errors
+func logThenErrorf(format string, args ...interface{}) error {
+	errorMsg := fmt.Sprintf(format, args...)
+	log.Error(errorMsg)
+	return errors.New(errorMsg)
+}
+
+// preimageLength is the size, in bytes, of the randomly generated HTLC secret.
+const preimageLength = 32
+
+// GenerateSecretHashPair creates a fresh random preimage and the
+// base64-encoded SHA-256 hash that locks an asset against it. The hash
+// is the raw 32-byte SHA-256 digest with no additional (e.g. keccak)
+// wrapping, matching the bytes32 hashlock Besu's AssetExchangeContract
+// expects, so the same pair can lock an asset on one chain and claim it
+// on the other.
+func GenerateSecretHashPair() (preimageBase64 string, hashBase64 string, err error) {
+	preimage := make([]byte, preimageLength)
+	if _, err := rand.Read(preimage); err != nil {
+		return "", "", logThenErrorf("failed to generate HTLC preimage: %s", err.Error())
+	}
+	preimageBase64 = base64.StdEncoding.EncodeToString(preimage)
+	hashBase64 = generateSHA256HashInBase64Form(preimage)
+	return preimageBase64, hashBase64, nil
+}
+
+// generateSHA256HashInBase64Form hashes preimage with SHA-256 and
+// base64-encodes the raw 32-byte digest.
+func generateSHA256HashInBase64Form(preimage []byte) string {
+	shaHash := sha256.Sum256(preimage)
+	return base64.StdEncoding.EncodeToString(shaHash[:])
+}
+
+// createAssetExchangeAgreementSerializedBase64 mirrors the equivalent
+// helper in the Fabric-to-Fabric asset-manager package: it builds the
+// AssetExchangeAgreement chaincode argument identifying which asset is
+// being locked/claimed/reclaimed and with whom.
+func createAssetExchangeAgreementSerializedBase64(assetType string, assetId string, recipientECertBase64 string, lockerECertBase64 string) (string, error) {
+	assetAgreement := &common.AssetExchangeAgreement{
+		AssetType: assetType,
+		Id:        assetId,
+		Recipient: recipientECertBase64,
+		Locker:    lockerECertBase64,
+	}
+	assetAgreementBytes, err := proto.Marshal(assetAgreement)
+	if err != nil {
+		return "", logThenErrorf(err.Error())
+	}
+	return base64.StdEncoding.EncodeToString(assetAgreementBytes), nil
+}
+
+// createFungibleAssetExchangeAgreementSerializedBase64 is the fungible
+// counterpart of createAssetExchangeAgreementSerializedBase64.
+func createFungibleAssetExchangeAgreementSerializedBase64(assetType string, numUnits uint64, recipientECertBase64 string, lockerECertBase64 string) (string, error) {
+	assetAgreement := &common.FungibleAssetExchangeAgreement{
+		AssetType: assetType,
+		NumUnits:  numUnits,
+		Recipient: recipientECertBase64,
+		Locker:    lockerECertBase64,
+	}
+	assetAgreementBytes, err := proto.Marshal(assetAgreement)
+	if err != nil {
+		return "", logThenErrorf(err.Error())
+	}
+	return base64.StdEncoding.EncodeToString(assetAgreementBytes), nil
+}
+
+// createAssetLockInfoSerializedBase64 builds the AssetLock chaincode
+// argument carrying the HTLC hashlock and expiry.
+func createAssetLockInfoSerializedBase64(hashBase64 string, expiryTimeSecs uint64) (string, error) {
+	lockInfoHTLC := &common.AssetLockHTLC{
+		HashBase64:     []byte(hashBase64),
+		ExpiryTimeSecs: expiryTimeSecs,
+		TimeSpec:       common.TimeSpec_EPOCH,
+	}
+	lockInfoHTLCBytes, err := proto.Marshal(lockInfoHTLC)
+	if err != nil {
+		return "", logThenErrorf(err.Error())
+	}
+
+	lockInfo := &common.AssetLock{
+		LockMechanism: common.LockMechanism_HTLC,
+		LockInfo:      lockInfoHTLCBytes,
+	}
+	lockInfoBytes, err := proto.Marshal(lockInfo)
+	if err != nil {
+		return "", logThenErrorf(err.Error())
+	}
+	return base64.StdEncoding.EncodeToString(lockInfoBytes), nil
+}
+
+// createAssetClaimInfoSerializedBase64 builds the AssetClaim chaincode
+// argument carrying the HTLC preimage.
+func createAssetClaimInfoSerializedBase64(hashPreimageBase64 string) (string, error) {
+	claimInfoHTLC := &common.AssetClaimHTLC{
+		HashPreimageBase64: []byte(hashPreimageBase64),
+	}
+	claimInfoHTLCBytes, err := proto.Marshal(claimInfoHTLC)
+	if err != nil {
+		return "", logThenErrorf(err.Error())
+	}
+
+	claimInfo := &common.AssetClaim{
+		LockMechanism: common.LockMechanism_HTLC,
+		ClaimInfo:     claimInfoHTLCBytes,
+	}
+	claimInfoBytes, err := proto.Marshal(claimInfo)
+	if err != nil {
+		return "", logThenErrorf(err.Error())
+	}
+	return base64.StdEncoding.EncodeToString(claimInfoBytes), nil
+}
+
+// LockAsset locks an asset for recipientECertBase64 under hashBase64
+// until expiryTimeSecs. For kind == NonFungible, assetId identifies the
+// asset and numUnits is ignored; for kind == Fungible, numUnits is the
+// quantity locked and assetId is ignored. Use GenerateSecretHashPair to
+// derive hashBase64 from a fresh preimage, or reuse the hash published on
+// the counterparty's contract when responding to a lock taken out there.
+func LockAsset(contract ContractHandler, kind AssetType, assetType string, assetId string, numUnits uint64,
+	recipientECertBase64 string, hashBase64 string, expiryTimeSecs uint64) (string, error) {
+	if contract == nil {
+		return "", logThenErrorf("contract handle not supplied")
+	}
+	if assetType == "" {
+		return "", logThenErrorf("asset type not supplied")
+	}
+	if recipientECertBase64 == "" {
+		return "", logThenErrorf("recipientECertBase64 not supplied")
+	}
+	if hashBase64 == "" {
+		return "", logThenErrorf("hashBase64 not supplied")
+	}
+	currentTimeSecs := uint64(time.Now().Unix())
+	if expiryTimeSecs <= currentTimeSecs {
+		return "", logThenErrorf("supplied expiry time in the past")
+	}
+
+	lockInfoStr, err := createAssetLockInfoSerializedBase64(hashBase64, expiryTimeSecs)
+	if err != nil {
+		return "", logThenErrorf(err.Error())
+	}
+
+	if kind == Fungible {
+		if numUnits == 0 {
+			return "", logThenErrorf("asset count must be a positive number")
+		}
+		assetAgreementStr, err := createFungibleAssetExchangeAgreementSerializedBase64(assetType, numUnits, recipientECertBase64, "")
+		if err != nil {
+			return "", logThenErrorf(err.Error())
+		}
+		result, err := contract.SubmitTransaction("LockFungibleAsset", assetAgreementStr, lockInfoStr)
+		if err != nil {
+			return "", logThenErrorf("error in contract.SubmitTransaction LockFungibleAsset: %s", err.Error())
+		}
+		return string(result), nil
+	}
+
+	if assetId == "" {
+		return "", logThenErrorf("asset id not supplied")
+	}
+	assetAgreementStr, err := createAssetExchangeAgreementSerializedBase64(assetType, assetId, recipientECertBase64, "")
+	if err != nil {
+		return "", logThenErrorf(err.Error())
+	}
+	result, err := contract.SubmitTransaction("LockAsset", assetAgreementStr, lockInfoStr)
+	if err != nil {
+		return "", logThenErrorf("error in contract.SubmitTransaction LockAsset: %s", err.Error())
+	}
+	return string(result), nil
+}
+
+// ClaimAsset claims a previously locked asset by presenting
+// hashPreimageBase64. assetIdOrContractId is the asset ID for kind ==
+// NonFungible, or the contract ID returned by LockAsset for kind ==
+// Fungible.
+func ClaimAsset(contract ContractHandler, kind AssetType, assetType string, assetIdOrContractId string,
+	lockerECertBase64 string, hashPreimageBase64 string) (string, error) {
+	if contract == nil {
+		return "", logThenErrorf("contract handle not supplied")
+	}
+	if assetIdOrContractId == "" {
+		return "", logThenErrorf("asset id not supplied")
+	}
+	if hashPreimageBase64 == "" {
+		return "", logThenErrorf("hashPreimageBase64 not supplied")
+	}
+
+	claimInfoStr, err := createAssetClaimInfoSerializedBase64(hashPreimageBase64)
+	if err != nil {
+		return "", logThenErrorf(err.Error())
+	}
+
+	if kind == Fungible {
+		result, err := contract.SubmitTransaction("ClaimFungibleAsset", assetIdOrContractId, claimInfoStr)
+		if err != nil {
+			return "", logThenErrorf("error in contract.SubmitTransaction ClaimFungibleAsset: %s", err.Error())
+		}
+		return string(result), nil
+	}
+
+	if assetType == "" {
+		return "", logThenErrorf("asset type not supplied")
+	}
+	if lockerECertBase64 == "" {
+		return "", logThenErrorf("lockerECertBase64 not supplied")
+	}
+	assetAgreementStr, err := createAssetExchangeAgreementSerializedBase64(assetType, assetIdOrContractId, "", lockerECertBase64)
+	if err != nil {
+		return "", logThenErrorf(err.Error())
+	}
+	result, err := contract.SubmitTransaction("ClaimAsset", assetAgreementStr, claimInfoStr)
+	if err != nil {
+		return "", logThenErrorf("error in contract.SubmitTransaction ClaimAsset: %s", err.Error())
+	}
+	return string(result), nil
+}
+
+// ReclaimAsset returns a locked asset to its locker after its expiry has
+// passed and it was never claimed. For kind == NonFungible,
+// recipientECertBase64 identifies the recipient the asset was locked for;
+// for kind == Fungible, assetIdOrContractId is the contract ID returned by
+// LockAsset and assetType and recipientECertBase64 are ignored.
+func ReclaimAsset(contract ContractHandler, kind AssetType, assetType string, assetIdOrContractId string, recipientECertBase64 string) (string, error) {
+	if contract == nil {
+		return "", logThenErrorf("contract handle not supplied")
+	}
+	if assetIdOrContractId == "" {
+		return "", logThenErrorf("asset id not supplied")
+	}
+
+	if kind == Fungible {
+		result, err := contract.SubmitTransaction("UnlockFungibleAsset", assetIdOrContractId)
+		if err != nil {
+			return "", logThenErrorf("error in contract.SubmitTransaction UnlockFungibleAsset: %s", err.Error())
+		}
+		return string(result), nil
+	}
+
+	if assetType == "" {
+		return "", logThenErrorf("asset type not supplied")
+	}
+	if recipientECertBase64 == "" {
+		return "", logThenErrorf("recipientECertBase64 not supplied")
+	}
+	assetAgreementStr, err := createAssetExchangeAgreementSerializedBase64(assetType, assetIdOrContractId, recipientECertBase64, "")
+	if err != nil {
+		return "", logThenErrorf(err.Error())
+	}
+	result, err := contract.SubmitTransaction("UnlockAsset", assetAgreementStr)
+	if err != nil {
+		return "", logThenErrorf("error in contract.SubmitTransaction UnlockAsset: %s", err.Error())
+	}
+	return string(result), nil
+}
+
+// IsAssetLocked reports whether the asset/contract identified by
+// assetIdOrContractId is currently locked.
+func IsAssetLocked(contract ContractHandler, kind AssetType, assetType string, assetIdOrContractId string, recipientECertBase64 string, lockerECertBase64 string) (string, error) {
+	if contract == nil {
+		return "", logThenErrorf("contract handle not supplied")
+	}
+	if assetIdOrContractId == "" {
+		return "", logThenErrorf("asset id not supplied")
+	}
+
+	if kind == Fungible {
+		result, err := contract.EvaluateTransaction("IsFungibleAssetLocked", assetIdOrContractId)
+		if err != nil {
+			return "", logThenErrorf("error in contract.EvaluateTransaction IsFungibleAssetLocked: %s", err.Error())
+		}
+		return string(result), nil
+	}
+
+	if assetType == "" {
+		return "", logThenErrorf("asset type not supplied")
+	}
+	if recipientECertBase64 == "" {
+		return "", logThenErrorf("recipientECertBase64 not supplied")
+	}
+	if lockerECertBase64 == "" {
+		return "", logThenErrorf("lockerECertBase64 not supplied")
+	}
+	assetAgreementStr, err := createAssetExchangeAgreementSerializedBase64(assetType, assetIdOrContractId, recipientECertBase64, lockerECertBase64)
+	if err != nil {
+		return "", logThenErrorf(err.Error())
+	}
+	result, err := contract.EvaluateTransaction("IsAssetLocked", assetAgreementStr)
+	if err != nil {
+		return "", logThenErrorf("error in contract.EvaluateTransaction IsAssetLocked: %s", err.Error())
+	}
+	return string(result), nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2020 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package assetexchange
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger-labs/weaver-dlt-interoperability/common/protos-go/common"
+	"github.com/stretchr/testify/require"
+)
+
+var submitTransactionMock func(ccFunc string, args ...string) ([]byte, error)
+var evaluateTransactionMock func(ccFunc string, args ...string) ([]byte, error)
+
+type contractHandlerMock struct{}
+
+func (chMock contractHandlerMock) SubmitTransaction(ccFunc string, args ...string) ([]byte, error) {
+	return submitTransactionMock(ccFunc, args...)
+}
+
+func (chMock contractHandlerMock) EvaluateTransaction(ccFunc string, args ...string) ([]byte, error) {
+	return evaluateTransactionMock(ccFunc, args...)
+}
+
+func TestGenerateSecretHashPairProducesBesuCompatibleHash(t *testing.T) {
+	preimageBase64, hashBase64, err := GenerateSecretHashPair()
+	require.NoError(t, err)
+
+	preimage, err := base64.StdEncoding.DecodeString(preimageBase64)
+	require.NoError(t, err)
+	require.Len(t, preimage, preimageLength)
+
+	rawHash, err := base64.StdEncoding.DecodeString(hashBase64)
+	require.NoError(t, err)
+	// Besu's AssetExchangeContract.sol declares its hashlock as
+	// bytes32, so the hash must be exactly 32 bytes of raw SHA-256
+	// digest with no ABI/keccak wrapping.
+	require.Len(t, rawHash, 32)
+	require.Equal(t, generateSHA256HashInBase64Form(preimage), hashBase64)
+}
+
+func TestLockAssetNonFungible(t *testing.T) {
+	contract := contractHandlerMock{}
+	var capturedFunc string
+	var capturedArgs []string
+	submitTransactionMock = func(ccFunc string, args ...string) ([]byte, error) {
+		capturedFunc = ccFunc
+		capturedArgs = args
+		return []byte("success"), nil
+	}
+
+	_, hashBase64, err := GenerateSecretHashPair()
+	require.NoError(t, err)
+	expiryTimeSecs := uint64(time.Now().Unix()) + 3600
+
+	expectedError := "contract handle not supplied"
+	_, err = LockAsset(nil, NonFungible, "bond", "asset1", 0, "recipientECertBase64", hashBase64, expiryTimeSecs)
+	require.EqualError(t, err, expectedError)
+
+	expectedError = "asset id not supplied"
+	_, err = LockAsset(contract, NonFungible, "bond", "", 0, "recipientECertBase64", hashBase64, expiryTimeSecs)
+	require.EqualError(t, err, expectedError)
+
+	result, err := LockAsset(contract, NonFungible, "bond", "asset1", 0, "recipientECertBase64", hashBase64, expiryTimeSecs)
+	require.NoError(t, err)
+	require.Equal(t, "success", result)
+	require.Equal(t, "LockAsset", capturedFunc)
+	require.Len(t, capturedArgs, 2)
+
+	assetAgreementBytes, err := base64.StdEncoding.DecodeString(capturedArgs[0])
+	require.NoError(t, err)
+	assetAgreement := &common.AssetExchangeAgreement{}
+	require.NoError(t, proto.Unmarshal(assetAgreementBytes, assetAgreement))
+	require.Equal(t, "bond", assetAgreement.AssetType)
+	require.Equal(t, "asset1", assetAgreement.Id)
+	require.Equal(t, "recipientECertBase64", assetAgreement.Recipient)
+}
+
+func TestLockAssetFungible(t *testing.T) {
+	contract := contractHandlerMock{}
+	var capturedFunc string
+	submitTransactionMock = func(ccFunc string, args ...string) ([]byte, error) {
+		capturedFunc = ccFunc
+		return []byte("contract-id"), nil
+	}
+
+	_, hashBase64, err := GenerateSecretHashPair()
+	require.NoError(t, err)
+	expiryTimeSecs := uint64(time.Now().Unix()) + 3600
+
+	expectedError := "asset count must be a positive number"
+	_, err = LockAsset(contract, Fungible, "token", "", 0, "recipientECertBase64", hashBase64, expiryTimeSecs)
+	require.EqualError(t, err, expectedError)
+
+	result, err := LockAsset(contract, Fungible, "token", "", 10, "recipientECertBase64", hashBase64, expiryTimeSecs)
+	require.NoError(t, err)
+	require.Equal(t, "contract-id", result)
+	require.Equal(t, "LockFungibleAsset", capturedFunc)
+}
+
+func TestClaimAssetRejectsWrongPreimage(t *testing.T) {
+	contract := contractHandlerMock{}
+	submitTransactionMock = func(ccFunc string, args ...string) ([]byte, error) {
+		return nil, errors.New("preimage does not match hashlock")
+	}
+
+	_, _, err := GenerateSecretHashPair()
+	require.NoError(t, err)
+	wrongPreimageBase64, _, err := GenerateSecretHashPair()
+	require.NoError(t, err)
+
+	_, err = ClaimAsset(contract, NonFungible, "bond", "asset1", "lockerECertBase64", wrongPreimageBase64)
+	require.Error(t, err)
+}
+
+func TestReclaimFungibleAsset(t *testing.T) {
+	contract := contractHandlerMock{}
+	var capturedFunc string
+	var capturedArgs []string
+	submitTransactionMock = func(ccFunc string, args ...string) ([]byte, error) {
+		capturedFunc = ccFunc
+		capturedArgs = args
+		return []byte(""), nil
+	}
+
+	_, err := ReclaimAsset(contract, Fungible, "token", "contract-id", "")
+	require.NoError(t, err)
+	require.Equal(t, "UnlockFungibleAsset", capturedFunc)
+	require.Equal(t, []string{"contract-id"}, capturedArgs)
+}
+
+func TestIsAssetLockedNonFungible(t *testing.T) {
+	contract := contractHandlerMock{}
+	evaluateTransactionMock = func(ccFunc string, args ...string) ([]byte, error) {
+		return []byte("true"), nil
+	}
+
+	result, err := IsAssetLocked(contract, NonFungible, "bond", "asset1", "recipientECertBase64", "lockerECertBase64")
+	require.NoError(t, err)
+	require.Equal(t, "true", result)
+}
@@ -0,0 +1,438 @@
+/*
+Copyright 2020 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package events exposes the event subscription APIs added in the
+// weaver protos at v1.6.2 (common.EventMatcher, common.EventSubscription
+// and friends). It drives the relay Network service's SubscribeEvent,
+// GetEventSubscriptionState, UnsubscribeEvent and GetEventStates
+// endpoints over connections taken from the relay package's
+// RelayClientPool, polling for subscription state and published events.
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hyperledger-labs/weaver-dlt-interoperability/common/protos-go/common"
+	"github.com/hyperledger-labs/weaver-dlt-interoperability/common/protos-go/networks"
+	"github.com/hyperledger-labs/weaver-dlt-interoperability/sdks/fabric/go-sdk/relay"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+// helper functions to log and return errors
+func logThenErrorf(format string, args ...interface{}) error {
+	errorMsg := fmt.Sprintf(format, args...)
+	log.Error(errorMsg)
+	return errors.New(errorMsg)
+}
+
+// networkClient is the subset of networks.NetworkClient this package
+// drives. It exists so tests can substitute a fake client without
+// standing up a real gRPC server; the networks.NetworkClient values
+// returned by networks.NewNetworkClient already satisfy it.
+type networkClient interface {
+	SubscribeEvent(ctx context.Context, in *networks.NetworkEventSubscription, opts ...grpc.CallOption) (*common.Ack, error)
+	GetEventSubscriptionState(ctx context.Context, in *networks.GetStateMessage, opts ...grpc.CallOption) (*common.EventSubscriptionState, error)
+	UnsubscribeEvent(ctx context.Context, in *networks.NetworkEventUnsubscription, opts ...grpc.CallOption) (*common.Ack, error)
+	GetEventStates(ctx context.Context, in *networks.GetStateMessage, opts ...grpc.CallOption) (*common.EventStates, error)
+}
+
+const defaultPollInterval = 2 * time.Second
+
+type EventSubscribe struct {
+	pool         *relay.RelayClientPool
+	relayName    string
+	timeoutSecs  uint64
+	pollInterval time.Duration
+
+	// client returns the networkClient to drive; overridable so tests can
+	// substitute a fake without dialing a real relay.
+	client func() (networkClient, error)
+
+	pumpsMutex sync.Mutex
+	pumps      map[string]chan struct{}
+}
+
+// Subscription is the handle SubscribeEvent returns: ID identifies the subscription with the
+// relay so it can later be passed to UnsubscribeEvent, and Events delivers one
+// *common.EventPublication per event published against it.
+type Subscription struct {
+	ID     string
+	Events <-chan *common.EventPublication
+}
+
+// registerPump tracks the stop channel for a subscription's pump goroutine so that a later
+// UnsubscribeEvent call for the same requestId can stop it directly.
+func (e *EventSubscribe) registerPump(requestId string, stop chan struct{}) {
+	e.pumpsMutex.Lock()
+	defer e.pumpsMutex.Unlock()
+	if e.pumps == nil {
+		e.pumps = make(map[string]chan struct{})
+	}
+	e.pumps[requestId] = stop
+}
+
+// stopPump signals and forgets the pump goroutine registered for requestId, if any.
+func (e *EventSubscribe) stopPump(requestId string) {
+	e.pumpsMutex.Lock()
+	defer e.pumpsMutex.Unlock()
+	if stop, ok := e.pumps[requestId]; ok {
+		close(stop)
+		delete(e.pumps, requestId)
+	}
+}
+
+// NewEventSubscribe builds an EventSubscribe that issues requests through
+// the given RelayClientPool against the named relay.
+func NewEventSubscribe(pool *relay.RelayClientPool, relayName string, timeout uint64) *EventSubscribe {
+	e := &EventSubscribe{
+		pool:         pool,
+		relayName:    relayName,
+		timeoutSecs:  timeout,
+		pollInterval: defaultPollInterval,
+	}
+	e.client = func() (networkClient, error) {
+		conn, err := e.pool.GetConn(e.relayName)
+		if err != nil {
+			return nil, logThenErrorf("failed to get connection to relay %s: %s", e.relayName, err.Error())
+		}
+		return networks.NewNetworkClient(conn), nil
+	}
+	return e
+}
+
+// NewEventSubscribeForEndPoint is a thin compatibility shim for call sites
+// still passing a single relay endpoint, as in the pre-pool API. It wraps
+// the endpoint in a single-entry static resolver and dials it in plaintext.
+func NewEventSubscribeForEndPoint(localEndPoint string, timeout uint64) *EventSubscribe {
+	pool := relay.NewRelayClientPool(relay.NewStaticResolver(map[string]string{localEndPoint: localEndPoint}), nil)
+	return NewEventSubscribe(pool, localEndPoint, timeout)
+}
+
+// NewContractTransactionPublication builds the publication spec used to
+// ask the relay to invoke a chaincode function with the published event
+// whenever a matching event is received. argReplaceIndex is the position
+// within ccArgs that the relay (and, on the receiving side, the
+// publications delivered on a Subscription's Events channel) overwrites
+// with the actual event payload once it arrives. PublishEvent itself
+// passes the arguments through unchanged.
+func NewContractTransactionPublication(driverId, ledgerId, contractId, ccFunc string, ccArgs [][]byte, argReplaceIndex uint64, members []string) *common.EventPublication {
+	return &common.EventPublication{
+		PublicationTarget: &common.EventPublication_Ctx{
+			Ctx: &common.ContractTransaction{
+				DriverId:        driverId,
+				LedgerId:        ledgerId,
+				ContractId:      contractId,
+				Func:            ccFunc,
+				Args:            ccArgs,
+				ReplaceArgIndex: argReplaceIndex,
+				Members:         members,
+			},
+		},
+	}
+}
+
+// NewAppURLPublication builds the publication spec used to ask the relay
+// to deliver a matching event to the given application URL instead of
+// invoking a chaincode function.
+func NewAppURLPublication(appURL string) *common.EventPublication {
+	return &common.EventPublication{
+		PublicationTarget: &common.EventPublication_AppUrl{
+			AppUrl: appURL,
+		},
+	}
+}
+
+// ContractInvoker is the minimal Fabric gateway contract surface PublishEvent
+// needs to carry out a delivered event's contract transaction locally.
+type ContractInvoker interface {
+	SubmitTransaction(ccFunc string, args ...string) ([]byte, error)
+}
+
+// PublishEvent is the chaincode-side counterpart to subscribing for events:
+// where a ContractTransaction publication spec tells the relay how it
+// should deliver a matching event, PublishEvent is what actually carries
+// that delivery out once the event arrives on the Events channel of the
+// Subscription returned by SubscribeEvent, by invoking the designated
+// chaincode function.
+func PublishEvent(contract ContractInvoker, publication *common.EventPublication) ([]byte, error) {
+	if contract == nil {
+		return nil, logThenErrorf("contract handle not supplied")
+	}
+	ctx := publication.GetCtx()
+	if ctx == nil {
+		return nil, logThenErrorf("event publication has no contract transaction to publish")
+	}
+
+	args := make([]string, len(ctx.Args))
+	for i, arg := range ctx.Args {
+		args[i] = string(arg)
+	}
+	result, err := contract.SubmitTransaction(ctx.Func, args...)
+	if err != nil {
+		return nil, logThenErrorf("failed to publish event via contract transaction %s: %s", ctx.Func, err.Error())
+	}
+	return result, nil
+}
+
+// queryWithAddress copies query's fields into a new *networks.NetworkQuery with Address set to
+// viewAddress, rather than copying the struct by value (it embeds a proto-internal mutex).
+func queryWithAddress(query *networks.NetworkQuery, viewAddress string) *networks.NetworkQuery {
+	return &networks.NetworkQuery{
+		Policy:             query.GetPolicy(),
+		Address:            viewAddress,
+		RequestingRelay:    query.GetRequestingRelay(),
+		RequestingNetwork:  query.GetRequestingNetwork(),
+		Certificate:        query.GetCertificate(),
+		RequestorSignature: query.GetRequestorSignature(),
+		Nonce:              query.GetNonce(),
+		RequestingOrg:      query.GetRequestingOrg(),
+		Confidential:       query.GetConfidential(),
+	}
+}
+
+// SubscribeEvent asks the local relay to subscribe to events matching eventMatcher on the
+// network reachable at viewAddress, blocking until the subscription itself is acknowledged as
+// SUBSCRIBED. It then returns a Subscription whose Events channel delivers a
+// *common.EventPublication for every event published against it, until ctx is cancelled or
+// UnsubscribeEvent is called with the returned Subscription.ID.
+func (e *EventSubscribe) SubscribeEvent(ctx context.Context, eventMatcher *common.EventMatcher, viewAddress string, query *networks.NetworkQuery,
+	publicationSpec *common.EventPublication) (*Subscription, error) {
+
+	client, err := e.client()
+	if err != nil {
+		return nil, logThenErrorf("SubscribeEvent() error: %s", err.Error())
+	}
+
+	networkQuery := queryWithAddress(query, viewAddress)
+	requestId, err := e.sendSubscription(ctx, client, eventMatcher, networkQuery, publicationSpec)
+	if err != nil {
+		return nil, logThenErrorf("sendSubscription() error: %s", err.Error())
+	}
+
+	subscriptionState, err := e.pollSubscriptionState(ctx, client, requestId)
+	if err != nil {
+		return nil, logThenErrorf("error to get subscription state: %s", err.Error())
+	}
+	if subscriptionState.GetStatus() == common.EventSubscriptionState_ERROR {
+		return nil, logThenErrorf("cannot subscribe to event: %s", subscriptionState.GetMessage())
+	}
+
+	stop := make(chan struct{})
+	e.registerPump(subscriptionState.RequestId, stop)
+	events := make(chan *common.EventPublication)
+	go e.pumpEvents(ctx, client, subscriptionState.RequestId, publicationSpec, events, stop)
+	return &Subscription{ID: subscriptionState.RequestId, Events: events}, nil
+}
+
+// UnsubscribeEvent requests the local relay to tear down the subscription identified by
+// subscriptionRequestId (the ID on the Subscription returned by SubscribeEvent), polls for the
+// unsubscription to be acknowledged on the local network, and stops that subscription's pump
+// goroutine. Uses the timeout provided by the class.
+func (e *EventSubscribe) UnsubscribeEvent(ctx context.Context, eventMatcher *common.EventMatcher, viewAddress string, query *networks.NetworkQuery,
+	publicationSpec *common.EventPublication, subscriptionRequestId string) (*common.EventSubscriptionState, error) {
+
+	client, err := e.client()
+	if err != nil {
+		return nil, logThenErrorf("UnsubscribeEvent() error: %s", err.Error())
+	}
+
+	networkQuery := queryWithAddress(query, viewAddress)
+	networkEventUnsubscription := &networks.NetworkEventUnsubscription{
+		Request: &networks.NetworkEventSubscription{
+			EventMatcher:         eventMatcher,
+			Query:                networkQuery,
+			EventPublicationSpec: publicationSpec,
+		},
+		RequestId: subscriptionRequestId,
+	}
+	var resp *common.Ack
+	err = e.pool.InvokeWithRetry(ctx, func(ctx context.Context) error {
+		var invokeErr error
+		resp, invokeErr = client.UnsubscribeEvent(ctx, networkEventUnsubscription)
+		return invokeErr
+	})
+	if err != nil {
+		return nil, logThenErrorf("error in grpc UnsubscribeEvent(): %v", err)
+	}
+
+	finalState, err := e.pollSubscriptionState(ctx, client, resp.RequestId)
+	if err != nil {
+		return nil, logThenErrorf("error to get subscription state: %s", err.Error())
+	}
+	if finalState.GetStatus() == common.EventSubscriptionState_ERROR {
+		return nil, logThenErrorf("cannot unsubscribe from event: %s", finalState.GetMessage())
+	}
+	e.stopPump(subscriptionRequestId)
+	return finalState, nil
+}
+
+// sendSubscription sends an event subscription request to a remote network using gRPC and the
+// relay, returning the ID of the subscription request.
+func (e *EventSubscribe) sendSubscription(ctx context.Context, client networkClient, eventMatcher *common.EventMatcher, query *networks.NetworkQuery,
+	publicationSpec *common.EventPublication) (string, error) {
+
+	networkEventSubscription := &networks.NetworkEventSubscription{
+		EventMatcher:         eventMatcher,
+		Query:                query,
+		EventPublicationSpec: publicationSpec,
+	}
+	var resp *common.Ack
+	err := e.pool.InvokeWithRetry(ctx, func(ctx context.Context) error {
+		var invokeErr error
+		resp, invokeErr = client.SubscribeEvent(ctx, networkEventSubscription)
+		return invokeErr
+	})
+	if err != nil {
+		return "", logThenErrorf("error in grpc SubscribeEvent(): %v", err)
+	}
+
+	return resp.RequestId, nil
+}
+
+// pollSubscriptionState polls getSubscriptionState at e.pollInterval, sleeping between
+// attempts instead of busy-looping, until the subscription leaves a pending status, ctx is
+// cancelled, or e.timeoutSecs elapses.
+func (e *EventSubscribe) pollSubscriptionState(ctx context.Context, client networkClient, requestID string) (*common.EventSubscriptionState, error) {
+	endTime := time.Now().Add(time.Duration(e.timeoutSecs) * time.Second)
+
+	for {
+		state, err := e.getSubscriptionState(ctx, client, requestID)
+		if err != nil {
+			return nil, logThenErrorf("getSubscriptionState() error: %s", err.Error())
+		}
+		if !isSubscriptionPending(state.GetStatus()) {
+			return state, nil
+		}
+		if time.Now().After(endTime) {
+			return nil, logThenErrorf("timeout: subscription state is still pending")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, logThenErrorf("context cancelled while polling subscription state: %s", ctx.Err().Error())
+		case <-time.After(e.pollInterval):
+		}
+	}
+}
+
+func isSubscriptionPending(status common.EventSubscriptionState_STATUS) bool {
+	return status == common.EventSubscriptionState_SUBSCRIBE_PENDING ||
+		status == common.EventSubscriptionState_SUBSCRIBE_PENDING_ACK ||
+		status == common.EventSubscriptionState_UNSUBSCRIBE_PENDING ||
+		status == common.EventSubscriptionState_UNSUBSCRIBE_PENDING_ACK
+}
+
+// getSubscriptionState gets the subscription request state from the local network.
+func (e *EventSubscribe) getSubscriptionState(ctx context.Context, client networkClient, requestId string) (*common.EventSubscriptionState, error) {
+	getStateMessage := &networks.GetStateMessage{
+		RequestId: requestId,
+	}
+	var subscriptionState *common.EventSubscriptionState
+	err := e.pool.InvokeWithRetry(ctx, func(ctx context.Context) error {
+		var invokeErr error
+		subscriptionState, invokeErr = client.GetEventSubscriptionState(ctx, getStateMessage)
+		return invokeErr
+	})
+	if err != nil {
+		return nil, logThenErrorf("error in grpc GetEventSubscriptionState(): %s", err.Error())
+	}
+	log.Debugf("subscriptionState: %v", subscriptionState)
+
+	return subscriptionState, nil
+}
+
+// getEventStates fetches the events published against a subscription since the last time it
+// was called. Note that the relay marks events as delivered as soon as they are fetched by the
+// client.
+func (e *EventSubscribe) getEventStates(ctx context.Context, client networkClient, requestId string) (*common.EventStates, error) {
+	getStateMessage := &networks.GetStateMessage{
+		RequestId: requestId,
+	}
+	var eventStates *common.EventStates
+	err := e.pool.InvokeWithRetry(ctx, func(ctx context.Context) error {
+		var invokeErr error
+		eventStates, invokeErr = client.GetEventStates(ctx, getStateMessage)
+		return invokeErr
+	})
+	if err != nil {
+		return nil, logThenErrorf("error in grpc GetEventStates(): %s", err.Error())
+	}
+
+	return eventStates, nil
+}
+
+// pumpEvents polls getEventStates at e.pollInterval and delivers one *common.EventPublication
+// per newly fetched event onto events, substituting the event's view payload into the
+// publication spec's ReplaceArgIndex when the spec targets a contract transaction. It stops,
+// closing events, when ctx is cancelled or stop is closed by UnsubscribeEvent.
+func (e *EventSubscribe) pumpEvents(ctx context.Context, client networkClient, requestId string, publicationSpec *common.EventPublication,
+	events chan<- *common.EventPublication, stop <-chan struct{}) {
+	defer close(events)
+
+	ticker := time.NewTicker(e.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		eventStates, err := e.getEventStates(ctx, client, requestId)
+		if err != nil {
+			log.Errorf("pumpEvents() stopping after getEventStates() error: %s", err.Error())
+			return
+		}
+		for _, eventState := range eventStates.GetStates() {
+			publication := eventPublicationFor(publicationSpec, eventState)
+			select {
+			case events <- publication:
+			case <-ctx.Done():
+				return
+			case <-stop:
+				return
+			}
+		}
+	}
+}
+
+// eventPublicationFor builds the *common.EventPublication delivered to a subscriber for a
+// single fetched event, substituting the event's view payload into the original publication
+// spec's designated argument when the spec targets a contract transaction.
+func eventPublicationFor(publicationSpec *common.EventPublication, eventState *common.EventState) *common.EventPublication {
+	ctx := publicationSpec.GetCtx()
+	if ctx == nil {
+		return publicationSpec
+	}
+
+	args := make([][]byte, len(ctx.Args))
+	copy(args, ctx.Args)
+	if view := eventState.GetState().GetView(); view != nil && ctx.ReplaceArgIndex < uint64(len(args)) {
+		args[ctx.ReplaceArgIndex] = view.GetData()
+	}
+
+	return &common.EventPublication{
+		PublicationTarget: &common.EventPublication_Ctx{
+			Ctx: &common.ContractTransaction{
+				DriverId:        ctx.DriverId,
+				LedgerId:        ctx.LedgerId,
+				ContractId:      ctx.ContractId,
+				Func:            ctx.Func,
+				Args:            args,
+				ReplaceArgIndex: ctx.ReplaceArgIndex,
+				Members:         ctx.Members,
+			},
+		},
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2020 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger-labs/weaver-dlt-interoperability/common/protos-go/common"
+	"github.com/hyperledger-labs/weaver-dlt-interoperability/common/protos-go/networks"
+	"github.com/hyperledger-labs/weaver-dlt-interoperability/sdks/fabric/go-sdk/relay"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// fakeNetworkClient implements the networkClient seam used by EventSubscribe, letting
+// tests drive subscription/event-fetch sequences without a real relay.
+type fakeNetworkClient struct {
+	mu sync.Mutex
+
+	subscribeEventMock            func(*networks.NetworkEventSubscription) (*common.Ack, error)
+	getEventSubscriptionStateMock func(*networks.GetStateMessage) (*common.EventSubscriptionState, error)
+	unsubscribeEventMock          func(*networks.NetworkEventUnsubscription) (*common.Ack, error)
+	getEventStatesMock            func(*networks.GetStateMessage) (*common.EventStates, error)
+}
+
+func (f *fakeNetworkClient) SubscribeEvent(ctx context.Context, in *networks.NetworkEventSubscription, opts ...grpc.CallOption) (*common.Ack, error) {
+	return f.subscribeEventMock(in)
+}
+
+func (f *fakeNetworkClient) GetEventSubscriptionState(ctx context.Context, in *networks.GetStateMessage, opts ...grpc.CallOption) (*common.EventSubscriptionState, error) {
+	return f.getEventSubscriptionStateMock(in)
+}
+
+func (f *fakeNetworkClient) UnsubscribeEvent(ctx context.Context, in *networks.NetworkEventUnsubscription, opts ...grpc.CallOption) (*common.Ack, error) {
+	return f.unsubscribeEventMock(in)
+}
+
+func (f *fakeNetworkClient) GetEventStates(ctx context.Context, in *networks.GetStateMessage, opts ...grpc.CallOption) (*common.EventStates, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.getEventStatesMock(in)
+}
+
+func newTestEventSubscribe(pollInterval time.Duration) *EventSubscribe {
+	return &EventSubscribe{
+		pool:         relay.NewRelayClientPool(relay.NewStaticResolver(nil), nil),
+		relayName:    "relay1",
+		timeoutSecs:  5,
+		pollInterval: pollInterval,
+	}
+}
+
+func TestSubscribeEventPollsUntilSubscribedThenDeliversEvents(t *testing.T) {
+	eventMatcher := &common.EventMatcher{EventType: common.EventType_LEDGER_STATE}
+	publicationSpec := NewContractTransactionPublication("driver1", "ledger1", "contract1", "processEvent", [][]byte{[]byte("placeholder")}, 0, nil)
+
+	subscriptionStateCalls := 0
+	client := &fakeNetworkClient{
+		subscribeEventMock: func(in *networks.NetworkEventSubscription) (*common.Ack, error) {
+			require.Equal(t, "localhost:9080", in.Query.Address)
+			return &common.Ack{RequestId: "req1"}, nil
+		},
+		getEventSubscriptionStateMock: func(in *networks.GetStateMessage) (*common.EventSubscriptionState, error) {
+			subscriptionStateCalls++
+			if subscriptionStateCalls < 2 {
+				return &common.EventSubscriptionState{RequestId: "req1", Status: common.EventSubscriptionState_SUBSCRIBE_PENDING}, nil
+			}
+			return &common.EventSubscriptionState{RequestId: "req1", Status: common.EventSubscriptionState_SUBSCRIBED}, nil
+		},
+		getEventStatesMock: func(in *networks.GetStateMessage) (*common.EventStates, error) {
+			return &common.EventStates{
+				States: []*common.EventState{
+					{
+						EventId: "event1",
+						State: &common.RequestState{
+							RequestId: "req1",
+							Status:    common.RequestState_EVENT_WRITTEN,
+							State:     &common.RequestState_View{View: &common.View{Data: []byte("event-payload")}},
+						},
+					},
+				},
+			}, nil
+		},
+	}
+
+	e := newTestEventSubscribe(5 * time.Millisecond)
+	e.client = func() (networkClient, error) { return client, nil }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscription, err := e.SubscribeEvent(ctx, eventMatcher, "localhost:9080", &networks.NetworkQuery{}, publicationSpec)
+	require.NoError(t, err)
+	require.Equal(t, 2, subscriptionStateCalls)
+	require.Equal(t, "req1", subscription.ID)
+
+	select {
+	case publication := <-subscription.Events:
+		require.Equal(t, "event-payload", string(publication.GetCtx().Args[0]))
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for published event")
+	}
+
+	cancel()
+	_, open := <-subscription.Events
+	require.False(t, open)
+}
+
+func TestUnsubscribeEventStopsTheMatchingPump(t *testing.T) {
+	eventMatcher := &common.EventMatcher{EventType: common.EventType_LEDGER_STATE}
+	publicationSpec := NewContractTransactionPublication("driver1", "ledger1", "contract1", "processEvent", [][]byte{[]byte("placeholder")}, 0, nil)
+
+	client := &fakeNetworkClient{
+		subscribeEventMock: func(in *networks.NetworkEventSubscription) (*common.Ack, error) {
+			return &common.Ack{RequestId: "req1"}, nil
+		},
+		getEventSubscriptionStateMock: func(in *networks.GetStateMessage) (*common.EventSubscriptionState, error) {
+			switch in.RequestId {
+			case "req1":
+				return &common.EventSubscriptionState{RequestId: "req1", Status: common.EventSubscriptionState_SUBSCRIBED}, nil
+			case "req2":
+				return &common.EventSubscriptionState{RequestId: "req2", Status: common.EventSubscriptionState_UNSUBSCRIBED}, nil
+			default:
+				t.Fatalf("unexpected requestId %s", in.RequestId)
+				return nil, nil
+			}
+		},
+		unsubscribeEventMock: func(in *networks.NetworkEventUnsubscription) (*common.Ack, error) {
+			require.Equal(t, "req1", in.RequestId)
+			return &common.Ack{RequestId: "req2"}, nil
+		},
+		getEventStatesMock: func(in *networks.GetStateMessage) (*common.EventStates, error) {
+			return &common.EventStates{}, nil
+		},
+	}
+
+	e := newTestEventSubscribe(5 * time.Millisecond)
+	e.client = func() (networkClient, error) { return client, nil }
+
+	// ctx is deliberately never cancelled: the pump should stop purely because of
+	// UnsubscribeEvent, not because of context cancellation.
+	subscription, err := e.SubscribeEvent(context.Background(), eventMatcher, "localhost:9080", &networks.NetworkQuery{}, publicationSpec)
+	require.NoError(t, err)
+
+	_, err = e.UnsubscribeEvent(context.Background(), eventMatcher, "localhost:9080", &networks.NetworkQuery{}, publicationSpec, subscription.ID)
+	require.NoError(t, err)
+
+	select {
+	case _, open := <-subscription.Events:
+		require.False(t, open)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for pump to stop after UnsubscribeEvent")
+	}
+}
+
+func TestSubscribeEventReturnsErrorOnSubscriptionFailure(t *testing.T) {
+	client := &fakeNetworkClient{
+		subscribeEventMock: func(in *networks.NetworkEventSubscription) (*common.Ack, error) {
+			return &common.Ack{RequestId: "req1"}, nil
+		},
+		getEventSubscriptionStateMock: func(in *networks.GetStateMessage) (*common.EventSubscriptionState, error) {
+			return &common.EventSubscriptionState{RequestId: "req1", Status: common.EventSubscriptionState_ERROR, Message: "no matching network"}, nil
+		},
+	}
+
+	e := newTestEventSubscribe(5 * time.Millisecond)
+	e.client = func() (networkClient, error) { return client, nil }
+
+	_, err := e.SubscribeEvent(context.Background(), &common.EventMatcher{}, "localhost:9080", &networks.NetworkQuery{}, NewAppURLPublication("https://example.com"))
+	require.EqualError(t, err, "cannot subscribe to event: no matching network")
+}
+
+func TestUnsubscribeEventPolls(t *testing.T) {
+	pollCalls := 0
+	client := &fakeNetworkClient{
+		unsubscribeEventMock: func(in *networks.NetworkEventUnsubscription) (*common.Ack, error) {
+			require.Equal(t, "req1", in.RequestId)
+			return &common.Ack{RequestId: "req2"}, nil
+		},
+		getEventSubscriptionStateMock: func(in *networks.GetStateMessage) (*common.EventSubscriptionState, error) {
+			pollCalls++
+			if pollCalls < 2 {
+				return &common.EventSubscriptionState{RequestId: "req2", Status: common.EventSubscriptionState_UNSUBSCRIBE_PENDING}, nil
+			}
+			return &common.EventSubscriptionState{RequestId: "req2", Status: common.EventSubscriptionState_UNSUBSCRIBED}, nil
+		},
+	}
+
+	e := newTestEventSubscribe(5 * time.Millisecond)
+	e.client = func() (networkClient, error) { return client, nil }
+
+	finalState, err := e.UnsubscribeEvent(context.Background(), &common.EventMatcher{}, "localhost:9080", &networks.NetworkQuery{}, NewAppURLPublication("https://example.com"), "req1")
+	require.NoError(t, err)
+	require.Equal(t, common.EventSubscriptionState_UNSUBSCRIBED, finalState.GetStatus())
+	require.Equal(t, 2, pollCalls)
+}
+
+func TestPublishEventInvokesContractTransaction(t *testing.T) {
+	var capturedFunc string
+	var capturedArgs []string
+	contract := contractInvokerMock{
+		submitTransactionMock: func(ccFunc string, args ...string) ([]byte, error) {
+			capturedFunc = ccFunc
+			capturedArgs = args
+			return []byte("success"), nil
+		},
+	}
+
+	publication := NewContractTransactionPublication("driver1", "ledger1", "contract1", "processEvent", [][]byte{[]byte("event-payload")}, 0, nil)
+
+	result, err := PublishEvent(contract, publication)
+	require.NoError(t, err)
+	require.Equal(t, "success", string(result))
+	require.Equal(t, "processEvent", capturedFunc)
+	require.Equal(t, []string{"event-payload"}, capturedArgs)
+}
+
+func TestPublishEventRejectsNonContractPublication(t *testing.T) {
+	_, err := PublishEvent(contractInvokerMock{}, NewAppURLPublication("https://example.com"))
+	require.EqualError(t, err, "event publication has no contract transaction to publish")
+}
+
+func TestPublishEventRejectsNilContract(t *testing.T) {
+	publication := NewContractTransactionPublication("driver1", "ledger1", "contract1", "processEvent", nil, 0, nil)
+	_, err := PublishEvent(nil, publication)
+	require.EqualError(t, err, "contract handle not supplied")
+}
+
+type contractInvokerMock struct {
+	submitTransactionMock func(ccFunc string, args ...string) ([]byte, error)
+}
+
+func (c contractInvokerMock) SubmitTransaction(ccFunc string, args ...string) ([]byte, error) {
+	if c.submitTransactionMock == nil {
+		return nil, errors.New("submitTransactionMock not set")
+	}
+	return c.submitTransactionMock(ccFunc, args...)
+}
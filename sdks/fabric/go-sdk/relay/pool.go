@@ -0,0 +1,227 @@
+/*
+Copyright 2020 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package relay provides a pooled, retrying gRPC transport to remote
+// relays. It replaces the historical pattern of dialing a fresh
+// connection for every request (still used directly by older call
+// sites) with a small set of long-lived, keepalive'd connections that
+// are reused across calls and shared by every helper in this SDK.
+package relay
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+)
+
+// helper functions to log and return errors
+func logThenErrorf(format string, args ...interface{}) error {
+	errorMsg := fmt.Sprintf(format, args...)
+	log.Error(errorMsg)
+	return errors.New(errorMsg)
+}
+
+// TLSConfig carries the material needed to dial a relay over mutual TLS.
+// CACertPaths are used to validate the relay's server certificate, while
+// ClientCertPath/ClientKeyPath are presented to relays that require a
+// client certificate. Leave a *TLSConfig nil to dial relays in plaintext.
+type TLSConfig struct {
+	CACertPaths    []string
+	ClientCertPath string
+	ClientKeyPath  string
+}
+
+func (t *TLSConfig) transportCredentials() (credentials.TransportCredentials, error) {
+	certPool := x509.NewCertPool()
+	for _, caCertPath := range t.CACertPaths {
+		caCertPEM, err := os.ReadFile(caCertPath)
+		if err != nil {
+			return nil, logThenErrorf("failed to read relay CA cert %s: %s", caCertPath, err.Error())
+		}
+		if !certPool.AppendCertsFromPEM(caCertPEM) {
+			return nil, logThenErrorf("failed to parse relay CA cert %s", caCertPath)
+		}
+	}
+
+	tlsConfig := &tls.Config{RootCAs: certPool}
+	if t.ClientCertPath != "" || t.ClientKeyPath != "" {
+		clientCert, err := tls.LoadX509KeyPair(t.ClientCertPath, t.ClientKeyPath)
+		if err != nil {
+			return nil, logThenErrorf("failed to load relay client cert/key: %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// Resolver discovers the dialable address of a relay given its logical
+// name, e.g. a Fabric channel config entry or an external service
+// registry, instead of having the address hardcoded at every call site.
+type Resolver interface {
+	Resolve(relayName string) (string, error)
+}
+
+// staticResolver is the degenerate Resolver used by the compatibility
+// shim, where the "name" passed around is already the dialable address.
+type staticResolver struct {
+	endpoints map[string]string
+}
+
+// NewStaticResolver returns a Resolver backed by a fixed relayName->address
+// map, for deployments where relay addresses are known up front.
+func NewStaticResolver(endpoints map[string]string) Resolver {
+	return &staticResolver{endpoints: endpoints}
+}
+
+func (s *staticResolver) Resolve(relayName string) (string, error) {
+	address, ok := s.endpoints[relayName]
+	if !ok {
+		return "", logThenErrorf("no address registered for relay %s", relayName)
+	}
+	return address, nil
+}
+
+const (
+	defaultMaxRetries  = 4
+	defaultBaseBackoff = 200 * time.Millisecond
+	defaultMaxBackoff  = 5 * time.Second
+)
+
+// RelayClientPool maintains one keepalive'd gRPC connection per relay
+// endpoint and wraps unary calls made over those connections with
+// exponential-backoff retries. Helpers that used to dial a relay
+// themselves should be constructed with a *RelayClientPool instead.
+type RelayClientPool struct {
+	resolver    Resolver
+	tlsConfig   *TLSConfig
+	maxRetries  uint
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+
+	mutex sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+// NewRelayClientPool returns a pool that resolves relay addresses via the
+// given Resolver. A nil tlsConfig dials relays in plaintext.
+func NewRelayClientPool(resolver Resolver, tlsConfig *TLSConfig) *RelayClientPool {
+	return &RelayClientPool{
+		resolver:    resolver,
+		tlsConfig:   tlsConfig,
+		maxRetries:  defaultMaxRetries,
+		baseBackoff: defaultBaseBackoff,
+		maxBackoff:  defaultMaxBackoff,
+		conns:       make(map[string]*grpc.ClientConn),
+	}
+}
+
+// GetConn returns the pooled connection for relayName, dialing and
+// caching one if this is the first request for that relay.
+func (p *RelayClientPool) GetConn(relayName string) (*grpc.ClientConn, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if conn, ok := p.conns[relayName]; ok {
+		return conn, nil
+	}
+
+	address, err := p.resolver.Resolve(relayName)
+	if err != nil {
+		return nil, logThenErrorf("failed to resolve relay %s: %s", relayName, err.Error())
+	}
+
+	dialOptions := []grpc.DialOption{
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                30 * time.Second,
+			Timeout:             10 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	}
+	if p.tlsConfig != nil {
+		creds, err := p.tlsConfig.transportCredentials()
+		if err != nil {
+			return nil, logThenErrorf("failed to build TLS credentials for relay %s: %s", relayName, err.Error())
+		}
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(creds))
+	} else {
+		dialOptions = append(dialOptions, grpc.WithInsecure())
+	}
+
+	conn, err := grpc.Dial(address, dialOptions...)
+	if err != nil {
+		return nil, logThenErrorf("grpc Dial() failed to connect to relay %s at %s: %s", relayName, address, err.Error())
+	}
+	p.conns[relayName] = conn
+	return conn, nil
+}
+
+// InvokeWithRetry runs call, retrying with exponential backoff and jitter
+// when it fails with the transient Unavailable or DeadlineExceeded codes.
+// Any other error, or exhausting the retry budget, is returned as-is.
+func (p *RelayClientPool) InvokeWithRetry(ctx context.Context, call func(ctx context.Context) error) error {
+	backoff := p.baseBackoff
+	var lastErr error
+	for attempt := uint(0); attempt <= p.maxRetries; attempt++ {
+		lastErr = call(ctx)
+		if lastErr == nil {
+			return nil
+		}
+		if !isRetryable(lastErr) || attempt == p.maxRetries {
+			return lastErr
+		}
+
+		sleep := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2+1)))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sleep):
+		}
+
+		backoff *= 2
+		if backoff > p.maxBackoff {
+			backoff = p.maxBackoff
+		}
+	}
+	return lastErr
+}
+
+func isRetryable(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	return st.Code() == codes.Unavailable || st.Code() == codes.DeadlineExceeded
+}
+
+// Close tears down every pooled connection. It is safe to call once the
+// pool is no longer needed, e.g. on process shutdown.
+func (p *RelayClientPool) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var lastErr error
+	for relayName, conn := range p.conns {
+		if err := conn.Close(); err != nil {
+			lastErr = logThenErrorf("failed to close connection to relay %s: %s", relayName, err.Error())
+		}
+	}
+	p.conns = make(map[string]*grpc.ClientConn)
+	return lastErr
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2020 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package relay
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStaticResolverResolve(t *testing.T) {
+	resolver := NewStaticResolver(map[string]string{"relay1": "localhost:9080"})
+
+	address, err := resolver.Resolve("relay1")
+	require.NoError(t, err)
+	require.Equal(t, "localhost:9080", address)
+
+	_, err = resolver.Resolve("unknown-relay")
+	require.EqualError(t, err, "no address registered for relay unknown-relay")
+}
+
+func TestInvokeWithRetrySucceedsAfterTransientUnavailable(t *testing.T) {
+	pool := NewRelayClientPool(NewStaticResolver(nil), nil)
+	pool.baseBackoff = 0
+
+	attempts := 0
+	err := pool.InvokeWithRetry(context.Background(), func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return status.Error(codes.Unavailable, "relay temporarily unavailable")
+		}
+		return nil
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, 3, attempts)
+}
+
+func TestInvokeWithRetryGivesUpOnNonRetryableError(t *testing.T) {
+	pool := NewRelayClientPool(NewStaticResolver(nil), nil)
+	pool.baseBackoff = 0
+
+	attempts := 0
+	err := pool.InvokeWithRetry(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return status.Error(codes.InvalidArgument, "bad request")
+	})
+
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+}
+
+func TestInvokeWithRetryExhaustsBudget(t *testing.T) {
+	pool := NewRelayClientPool(NewStaticResolver(nil), nil)
+	pool.baseBackoff = 0
+	pool.maxRetries = 2
+
+	attempts := 0
+	err := pool.InvokeWithRetry(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return status.Error(codes.DeadlineExceeded, "timed out")
+	})
+
+	require.Error(t, err)
+	require.Equal(t, 3, attempts) // initial attempt + 2 retries
+}
+
+func TestIsRetryableIgnoresNonGRPCErrors(t *testing.T) {
+	require.False(t, isRetryable(errors.New("plain error")))
+}
+
+func TestGetConnFailsWhenResolverHasNoEntry(t *testing.T) {
+	pool := NewRelayClientPool(NewStaticResolver(nil), nil)
+
+	_, err := pool.GetConn("relay1")
+	require.Error(t, err)
+}